@@ -0,0 +1,111 @@
+package types
+
+import (
+	"time"
+
+	registry "github.com/docker/docker/api/types/registry"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/context"
+)
+
+// ServiceCreateOptions contains the options to use when creating a service.
+type ServiceCreateOptions struct {
+	// EncodedRegistryAuth is the encoded registry authorization credentials to
+	// use when creating the service.
+	EncodedRegistryAuth string
+
+	// QueryRegistry indicates whether the service create should contact the
+	// registry to resolve additional information about the service's image,
+	// such as its digest and supported platforms.
+	QueryRegistry bool
+
+	// TrustedResolve requests that the image tag be resolved to a digest
+	// using signed trust data from a Notary/TUF server rather than the
+	// registry-reported digest. If no trust data can be established for the
+	// tag, ServiceCreate returns an error instead of falling back to an
+	// unsigned digest.
+	TrustedResolve bool
+
+	// NotaryServer overrides the Notary server consulted when
+	// TrustedResolve is set. It defaults to the official Docker Content
+	// Trust server.
+	NotaryServer string
+
+	// NotaryRootKeys pins, by GUN, the hex-encoded public key trusted to
+	// sign that GUN's root.json. TrustedResolve requires the fetched
+	// root.json to carry a valid signature from this key, rather than
+	// accepting whatever key root.json itself names: without a pinned
+	// anchor, a compromised registry or a MITM could mint its own
+	// internally-consistent root and have any digest it likes accepted. A
+	// GUN with no entry here is not trusted; TrustedResolve returns an
+	// error rather than falling back to unpinned trust-on-first-use.
+	NotaryRootKeys map[string]string
+
+	// PlatformConstraints restricts which of a multi-arch image's platforms
+	// are considered compatible when QueryRegistry resolves a manifest
+	// list. Any platform already set on the service spec's
+	// Placement.Platforms constrains the result as well: a platform must
+	// satisfy both to be compatible. All advertised platforms are
+	// compatible if neither is set.
+	PlatformConstraints []v1.Platform
+
+	// PlatformPreferenceOrder orders the compatible platforms emitted into
+	// Placement.Platforms, most-preferred first. Compatible platforms not
+	// listed here are appended afterwards in their original order.
+	PlatformPreferenceOrder []v1.Platform
+
+	// DecryptionKeys holds OCI image decryption key material (PGP private
+	// keys, JWE recipient private keys, or PKCS7 certificate/key pairs) used
+	// to decrypt an OCI-encrypted image's config and layers. ServiceCreate
+	// base64-encodes this list into the X-Registry-DecryptConfig header. If
+	// QueryRegistry resolves an encrypted image and no keys are supplied,
+	// ServiceCreate returns an error instead of creating the service.
+	DecryptionKeys []string
+
+	// Resolver overrides the default HTTP-backed distribution resolver used
+	// when QueryRegistry is set, e.g. to serve digests from a local
+	// content-addressable cache or a mirror registry list instead of
+	// contacting the upstream registry for every ServiceCreate call.
+	Resolver DistributionResolver
+
+	// ResolveRetry configures retry with backoff for the distribution
+	// lookup performed when QueryRegistry is set.
+	ResolveRetry ResolveRetry
+}
+
+// DistributionResolver resolves an image reference to the manifest (or
+// manifest list) information the daemon's /distribution/ endpoint would
+// otherwise provide: its digest and the platforms it supports. ServiceCreate
+// uses the default HTTP-backed resolver unless ServiceCreateOptions.Resolver
+// is set.
+type DistributionResolver interface {
+	DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error)
+}
+
+// ResolveRetry configures retry with exponential backoff for the
+// distribution lookup ServiceCreate performs when QueryRegistry is set.
+// Transient failures (5xx responses, network errors) are retried; permanent
+// ones (not found, authentication/authorization failures) fail immediately.
+type ResolveRetry struct {
+	// MaxAttempts is the maximum number of resolution attempts. Zero or
+	// negative means a single attempt (no retry).
+	MaxAttempts int
+
+	// InitialInterval is the delay before the first retry. It defaults to
+	// 100ms if zero or negative.
+	InitialInterval time.Duration
+
+	// MaxInterval caps the exponentially-increasing delay between retries.
+	// It defaults to 30s if zero or negative.
+	MaxInterval time.Duration
+}
+
+// ServiceCreateResponse contains the information returned to a client on the
+// creation of a new service.
+type ServiceCreateResponse struct {
+	// ID is the ID of the created service.
+	ID string
+
+	// Warnings is a set of non-fatal warning messages to pass on to the user.
+	Warnings []string `json:",omitempty"`
+}
@@ -0,0 +1,27 @@
+package registry
+
+import v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+// DistributionInspect contains the result of a manifest inspection done by
+// the daemon's /distribution/ endpoint: the resolved manifest (or manifest
+// list) descriptor, the platforms it supports, and — when the manifest
+// describes a single image rather than a list — the config and layer
+// descriptors, so callers can detect OCI image encryption ("+encrypted"
+// media types) without a separate round-trip to the registry.
+type DistributionInspect struct {
+	// Descriptor is the content descriptor of the resolved manifest (or
+	// manifest list).
+	Descriptor v1.Descriptor
+
+	// Platforms lists the platforms a manifest list advertises. It is
+	// empty for a single-platform manifest.
+	Platforms []v1.Platform
+
+	// Config is the resolved manifest's config descriptor. It is empty
+	// for a manifest list, which has no config of its own.
+	Config v1.Descriptor `json:",omitempty"`
+
+	// Layers lists the resolved manifest's layer descriptors. It is empty
+	// for a manifest list.
+	Layers []v1.Descriptor `json:",omitempty"`
+}
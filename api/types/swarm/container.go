@@ -0,0 +1,30 @@
+package swarm
+
+// ContainerSpec represents the spec of a container.
+type ContainerSpec struct {
+	// Image is the image name to use for the container.
+	Image string `json:",omitempty"`
+
+	// EncryptionConfig carries the decryption key material needed to unlock
+	// an OCI-encrypted image's config and/or layers. It is populated by
+	// Client.ServiceCreate when QueryRegistry resolves an image with
+	// "+encrypted" layers or config, and consumed by the daemon when
+	// pulling the image.
+	EncryptionConfig *EncryptionConfig `json:",omitempty"`
+}
+
+// EncryptionConfig holds OCI image decryption key material together with
+// which parts of the image it decrypts.
+type EncryptionConfig struct {
+	// Keys holds OCI image decryption key material (PGP private keys, JWE
+	// recipient private keys, or PKCS7 certificate/key pairs).
+	Keys []string `json:",omitempty"`
+
+	// ConfigLayer reports whether the image's config carries an OCI
+	// "+encrypted" media type and therefore needs decrypting.
+	ConfigLayer bool `json:",omitempty"`
+
+	// LayerIndexes lists the indexes into the image's layers that carry an
+	// OCI "+encrypted" media type and therefore need decrypting.
+	LayerIndexes []int `json:",omitempty"`
+}
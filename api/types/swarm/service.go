@@ -0,0 +1,7 @@
+package swarm
+
+// ServiceSpec represents the spec of a service.
+type ServiceSpec struct {
+	// TaskTemplate describes the task the service runs on each node.
+	TaskTemplate TaskSpec `json:",omitempty"`
+}
@@ -0,0 +1,27 @@
+package swarm
+
+// TaskSpec represents the spec of a task.
+type TaskSpec struct {
+	// ContainerSpec describes the container this task runs.
+	ContainerSpec ContainerSpec `json:",omitempty"`
+
+	// Placement constrains which nodes a task may be scheduled on.
+	Placement *Placement `json:",omitempty"`
+}
+
+// Placement represents the placement constraints for a task.
+type Placement struct {
+	// Platforms restricts scheduling to nodes running one of these
+	// platforms. An empty list means any platform is acceptable.
+	Platforms []Platform `json:",omitempty"`
+}
+
+// Platform represents the platform (Arch/OS) a node runs, or a task
+// requires.
+type Platform struct {
+	// Architecture e.g. amd64
+	Architecture string `json:",omitempty"`
+
+	// OS e.g. linux
+	OS string `json:",omitempty"`
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types"
+	registrytypes "github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/errdefs"
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultResolveInitialInterval = 100 * time.Millisecond
+	defaultResolveMaxInterval     = 30 * time.Second
+)
+
+// httpDistributionResolver is the default types.DistributionResolver,
+// backed by the daemon's /distribution/ endpoint.
+type httpDistributionResolver struct {
+	cli *Client
+}
+
+func (r httpDistributionResolver) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registrytypes.DistributionInspect, error) {
+	return r.cli.distributionInspect(ctx, image, encodedRegistryAuth)
+}
+
+// resolveDistributionInspect resolves image via resolver (options.Resolver,
+// defaulting to the daemon's /distribution/ endpoint), retrying transient
+// failures with exponential backoff per options.ResolveRetry. Permanent
+// failures - not found, or an authentication/authorization rejection - fail
+// immediately without retrying.
+func (cli *Client) resolveDistributionInspect(ctx context.Context, image string, options types.ServiceCreateOptions) (registrytypes.DistributionInspect, error) {
+	resolver := options.Resolver
+	if resolver == nil {
+		resolver = httpDistributionResolver{cli: cli}
+	}
+
+	attempts := options.ResolveRetry.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	interval := options.ResolveRetry.InitialInterval
+	if interval <= 0 {
+		interval = defaultResolveInitialInterval
+	}
+	maxInterval := options.ResolveRetry.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = defaultResolveMaxInterval
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		inspect, err := resolver.DistributionInspect(ctx, image, options.EncodedRegistryAuth)
+		if err == nil {
+			return inspect, nil
+		}
+		if isPermanentResolveError(err) || attempt == attempts-1 {
+			return registrytypes.DistributionInspect{}, err
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return registrytypes.DistributionInspect{}, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+	return registrytypes.DistributionInspect{}, lastErr
+}
+
+// isPermanentResolveError reports whether err represents a failure that
+// retrying would not fix: the image was not found, or the request was
+// rejected as unauthenticated/unauthorized. Anything else (5xx responses,
+// network errors) is treated as transient.
+func isPermanentResolveError(err error) bool {
+	return errdefs.IsNotFound(err) || errdefs.IsUnauthorized(err) || errdefs.IsForbidden(err)
+}
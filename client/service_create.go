@@ -0,0 +1,319 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	registrytypes "github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/swarm"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/context"
+)
+
+// encryptedMediaTypeSuffix marks an OCI image config or layer as requiring
+// decryption before it can be unpacked, per the OCI image encryption spec.
+const encryptedMediaTypeSuffix = "+encrypted"
+
+// MissingDecryptionKeysError is returned when ServiceCreate resolves an
+// OCI-encrypted image but no decryption keys were supplied to unlock it.
+type MissingDecryptionKeysError struct {
+	Image string
+}
+
+func (e *MissingDecryptionKeysError) Error() string {
+	return fmt.Sprintf("image %s is encrypted but no decryption keys were provided", e.Image)
+}
+
+// ServiceCreate creates a new service.
+func (cli *Client) ServiceCreate(ctx context.Context, service swarm.ServiceSpec, options types.ServiceCreateOptions) (types.ServiceCreateResponse, error) {
+	var headers map[string][]string
+	if options.EncodedRegistryAuth != "" {
+		headers = map[string][]string{
+			"X-Registry-Auth": {options.EncodedRegistryAuth},
+		}
+	}
+	if len(options.DecryptionKeys) > 0 {
+		encodedDecryptConfig, err := encodeDecryptionKeys(options.DecryptionKeys)
+		if err != nil {
+			return types.ServiceCreateResponse{}, err
+		}
+		if headers == nil {
+			headers = map[string][]string{}
+		}
+		headers["X-Registry-DecryptConfig"] = []string{encodedDecryptConfig}
+	}
+
+	if options.QueryRegistry || options.TrustedResolve {
+		if err := cli.resolveServiceImageDigest(ctx, &service, options); err != nil {
+			return types.ServiceCreateResponse{}, err
+		}
+	}
+
+	var response types.ServiceCreateResponse
+	resp, err := cli.post(ctx, "/services/create", nil, service, headers)
+	defer ensureReaderClosed(resp)
+	if err != nil {
+		return response, err
+	}
+
+	err = json.NewDecoder(resp.body).Decode(&response)
+	return response, err
+}
+
+// resolveServiceImageDigest pins the service's container image to a digest,
+// querying the registry or, if trusted resolution was requested, a Notary/TUF
+// trust server instead. A valid reference without a digest is normalised to
+// name:tag, and is left unpinned in that form if the registry cannot resolve
+// it and the caller did not request trusted resolution.
+func (cli *Client) resolveServiceImageDigest(ctx context.Context, service *swarm.ServiceSpec, options types.ServiceCreateOptions) error {
+	image := service.TaskTemplate.ContainerSpec.Image
+
+	namedRef, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		// not a valid image reference: leave it exactly as provided
+		return nil
+	}
+
+	if _, isCanonical := namedRef.(reference.Canonical); isCanonical {
+		// already pinned to a digest: nothing further to resolve
+		service.TaskTemplate.ContainerSpec.Image = reference.FamiliarString(namedRef)
+		return nil
+	}
+
+	namedRef = reference.TagNameOnly(namedRef)
+	service.TaskTemplate.ContainerSpec.Image = reference.FamiliarString(namedRef)
+
+	taggedRef, ok := namedRef.(reference.NamedTagged)
+	if !ok {
+		return nil
+	}
+
+	if options.TrustedResolve {
+		dgst, err := cli.trustedImageDigest(ctx, taggedRef, options.NotaryServer, options.NotaryRootKeys)
+		if err != nil {
+			return err
+		}
+		return cli.pinServiceImageDigest(service, taggedRef, dgst)
+	}
+
+	distributionInspect, err := cli.resolveDistributionInspect(ctx, reference.FamiliarString(taggedRef), options)
+	if err != nil {
+		// unresolvable image: this is not considered fatal, the tag is used as-is
+		return nil
+	}
+
+	if len(distributionInspect.Platforms) > 0 {
+		compatible := compatiblePlatforms(
+			distributionInspect.Platforms,
+			options.PlatformConstraints,
+			placementPlatforms(service.TaskTemplate.Placement),
+			options.PlatformPreferenceOrder,
+		)
+		if len(compatible) == 0 {
+			return fmt.Errorf("image %s has no platform compatible with the requested constraints", reference.FamiliarString(taggedRef))
+		}
+
+		// swarm.Platform has no Variant field, so distinct variants of the
+		// same OS/architecture (e.g. arm/v7 and arm/v6) collapse to the same
+		// entry here; dedupe on OS/architecture alone to avoid emitting the
+		// same entry more than once.
+		swarmPlatforms := make([]swarm.Platform, 0, len(compatible))
+		seenOSArch := make(map[string]bool, len(compatible))
+		for _, p := range compatible {
+			osArch := p.OS + "/" + p.Architecture
+			if seenOSArch[osArch] {
+				continue
+			}
+			seenOSArch[osArch] = true
+			swarmPlatforms = append(swarmPlatforms, swarm.Platform{Architecture: p.Architecture, OS: p.OS})
+		}
+		service.TaskTemplate.Placement = placementWithPlatforms(service.TaskTemplate.Placement, swarmPlatforms)
+	}
+
+	if encryptedConfig, encryptedLayers := encryptedLayerIndexes(distributionInspect); encryptedConfig || len(encryptedLayers) > 0 {
+		if len(options.DecryptionKeys) == 0 {
+			return &MissingDecryptionKeysError{Image: reference.FamiliarString(taggedRef)}
+		}
+		service.TaskTemplate.ContainerSpec.EncryptionConfig = &swarm.EncryptionConfig{
+			Keys:         options.DecryptionKeys,
+			ConfigLayer:  encryptedConfig,
+			LayerIndexes: encryptedLayers,
+		}
+	}
+
+	if distributionInspect.Descriptor.Digest == "" {
+		return nil
+	}
+
+	// Pin the digest of the manifest list itself (as returned by the
+	// registry) so that the daemon can pull the correct child manifest for
+	// each node's platform, rather than pinning a single arch's manifest.
+	return cli.pinServiceImageDigest(service, taggedRef, distributionInspect.Descriptor.Digest.String())
+}
+
+// encryptedLayerIndexes reports whether the resolved manifest's config
+// carries an OCI "+encrypted" media type, and the indexes into its Layers
+// that do.
+func encryptedLayerIndexes(inspect registrytypes.DistributionInspect) (config bool, layers []int) {
+	config = strings.HasSuffix(inspect.Config.MediaType, encryptedMediaTypeSuffix)
+	for i, l := range inspect.Layers {
+		if strings.HasSuffix(l.MediaType, encryptedMediaTypeSuffix) {
+			layers = append(layers, i)
+		}
+	}
+	return config, layers
+}
+
+// encodeDecryptionKeys base64-encodes the caller's OCI decryption key
+// material (PGP private keys, JWE recipients, or PKCS7 certs+keys) for
+// transport in the X-Registry-DecryptConfig header.
+func encodeDecryptionKeys(keys []string) (string, error) {
+	raw, err := json.Marshal(keys)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(raw), nil
+}
+
+// placementPlatforms returns the platforms already set on a service's
+// placement, or nil if none were specified.
+func placementPlatforms(placement *swarm.Placement) []swarm.Platform {
+	if placement == nil {
+		return nil
+	}
+	return placement.Platforms
+}
+
+// compatiblePlatforms intersects the platforms a multi-arch manifest list
+// advertises with the caller's constraints (from PlatformConstraints and any
+// platforms already set on the service's placement), then orders the result
+// according to preference, most-preferred first. Platforms not named in
+// preference are appended afterwards in their original order.
+func compatiblePlatforms(available []v1.Platform, constraints []v1.Platform, existing []swarm.Platform, preference []v1.Platform) []v1.Platform {
+	existingConstraints := make([]v1.Platform, 0, len(existing))
+	for _, p := range existing {
+		existingConstraints = append(existingConstraints, v1.Platform{Architecture: p.Architecture, OS: p.OS})
+	}
+
+	var compatible []v1.Platform
+	for _, p := range available {
+		// existingConstraints come from swarm.Platform, which has no
+		// Variant field, so it is matched on OS/architecture alone;
+		// constraints (v1.Platform) can express Variant and are matched on
+		// the full tuple.
+		if platformMatchesAny(p, constraints) && platformMatchesAnyOSArch(p, existingConstraints) {
+			compatible = append(compatible, p)
+		}
+	}
+
+	if len(preference) == 0 {
+		return compatible
+	}
+
+	ordered := make([]v1.Platform, 0, len(compatible))
+	seen := make(map[string]bool, len(compatible))
+	for _, pref := range preference {
+		for _, p := range compatible {
+			if platformEqual(p, pref) && !seen[platformKey(p)] {
+				ordered = append(ordered, p)
+				seen[platformKey(p)] = true
+			}
+		}
+	}
+	for _, p := range compatible {
+		if !seen[platformKey(p)] {
+			ordered = append(ordered, p)
+			seen[platformKey(p)] = true
+		}
+	}
+	return ordered
+}
+
+// platformMatchesAny reports whether p satisfies any of filters, or true if
+// filters is empty (no constraint).
+func platformMatchesAny(p v1.Platform, filters []v1.Platform) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if platformEqual(p, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// platformMatchesAnyOSArch reports whether p's OS/architecture pair matches
+// any of filters, ignoring Variant, or true if filters is empty.
+func platformMatchesAnyOSArch(p v1.Platform, filters []v1.Platform) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if p.Architecture == f.Architecture && p.OS == f.OS {
+			return true
+		}
+	}
+	return false
+}
+
+// platformEqual compares the full platform tuple, including Variant, so
+// that e.g. arm/v7 and arm/v6 are treated as distinct platforms.
+func platformEqual(a, b v1.Platform) bool {
+	return a.Architecture == b.Architecture && a.OS == b.OS && a.Variant == b.Variant
+}
+
+func platformKey(p v1.Platform) string {
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// pinServiceImageDigest rewrites the service's container image to
+// name:tag@digest.
+func (cli *Client) pinServiceImageDigest(service *swarm.ServiceSpec, taggedRef reference.NamedTagged, dgst string) error {
+	namedDigestedRef, err := reference.WithDigest(taggedRef, digest.Digest(dgst))
+	if err != nil {
+		return err
+	}
+	service.TaskTemplate.ContainerSpec.Image = reference.FamiliarString(namedDigestedRef)
+	return nil
+}
+
+// distributionInspect queries the daemon's /distribution/ endpoint for the
+// manifest digest and supported platforms of image.
+func (cli *Client) distributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registrytypes.DistributionInspect, error) {
+	var distributionInspect registrytypes.DistributionInspect
+	if image == "" {
+		return distributionInspect, fmt.Errorf("no image specified")
+	}
+
+	var headers map[string][]string
+	if encodedRegistryAuth != "" {
+		headers = map[string][]string{
+			"X-Registry-Auth": {encodedRegistryAuth},
+		}
+	}
+
+	resp, err := cli.get(ctx, "/distribution/"+image, nil, headers)
+	defer ensureReaderClosed(resp)
+	if err != nil {
+		return distributionInspect, err
+	}
+
+	err = json.NewDecoder(resp.body).Decode(&distributionInspect)
+	return distributionInspect, err
+}
+
+// placementWithPlatforms returns placement with platforms set, allocating a
+// new swarm.Placement if none was provided.
+func placementWithPlatforms(placement *swarm.Placement, platforms []swarm.Platform) *swarm.Placement {
+	if placement == nil {
+		placement = &swarm.Placement{}
+	}
+	placement.Platforms = platforms
+	return placement
+}
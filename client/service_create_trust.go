@@ -0,0 +1,418 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"golang.org/x/net/context"
+)
+
+// defaultNotaryServer is the Notary/TUF server consulted for trusted
+// resolution when types.ServiceCreateOptions.NotaryServer is left empty.
+const defaultNotaryServer = "https://notary.docker.io"
+
+// TrustedResolveError is returned when trusted resolution was requested but
+// no signed digest could be established for an image tag.
+type TrustedResolveError struct {
+	Image string
+	Err   error
+}
+
+func (e *TrustedResolveError) Error() string {
+	return fmt.Sprintf("could not resolve a trusted digest for %s: %v", e.Image, e.Err)
+}
+
+// trustedImageDigest resolves ref's tag to a digest signed by the GUN's
+// Notary/TUF trust data. The GUN is ref's canonical (fully-qualified)
+// repository name, matching the name Notary indexes trust data under,
+// rather than the shortened name Docker Hub images are usually displayed
+// with. rootKeys pins, by GUN, the hex-encoded public key trusted to sign
+// that GUN's root.json; a GUN with no entry is refused rather than trusted
+// on first use.
+func (cli *Client) trustedImageDigest(ctx context.Context, ref reference.NamedTagged, notaryServer string, rootKeys map[string]string) (string, error) {
+	if notaryServer == "" {
+		notaryServer = defaultNotaryServer
+	}
+
+	gun := ref.Name()
+	dgst, err := newNotaryClient(notaryServer).getTargetDigest(ctx, gun, ref.Tag(), rootKeys[gun])
+	if err != nil {
+		return "", &TrustedResolveError{Image: reference.FamiliarString(ref), Err: err}
+	}
+	return dgst, nil
+}
+
+// tufSignature is a single signature over a TUF role's signed content.
+type tufSignature struct {
+	KeyID  string `json:"keyid"`
+	Method string `json:"method"`
+	Sig    string `json:"sig"`
+}
+
+// tufSigned is the standard TUF envelope: the role's content plus the
+// signatures asserting it. Signed is kept undecoded so that signature
+// verification runs against the exact bytes that were signed, rather than
+// a re-serialization of them.
+type tufSigned struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []tufSignature  `json:"signatures"`
+}
+
+// tufKey is a public key as recorded in root.json.
+type tufKey struct {
+	KeyType string `json:"keytype"`
+	KeyVal  struct {
+		Public string `json:"public"`
+	} `json:"keyval"`
+}
+
+// tufRole pins the keys and signature threshold root.json requires to trust
+// a role's metadata.
+type tufRole struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+// tufRootSigned is the signed content of root.json: the root of trust for
+// every other role's keys and signature thresholds.
+type tufRootSigned struct {
+	Expires time.Time          `json:"expires"`
+	Keys    map[string]tufKey  `json:"keys"`
+	Roles   map[string]tufRole `json:"roles"`
+}
+
+// tufHash is a TUF "hashes"/"length" pair as recorded against a role or
+// target in the role metadata that references it.
+type tufHash struct {
+	Hashes map[string]string `json:"hashes"`
+	Length int64             `json:"length"`
+}
+
+type tufTimestampSigned struct {
+	Expires time.Time          `json:"expires"`
+	Meta    map[string]tufHash `json:"meta"`
+}
+
+type tufSnapshotSigned struct {
+	Expires time.Time          `json:"expires"`
+	Meta    map[string]tufHash `json:"meta"`
+}
+
+type tufTargetsSigned struct {
+	Expires time.Time          `json:"expires"`
+	Targets map[string]tufHash `json:"targets"`
+}
+
+// notaryClient fetches and verifies a GUN's TUF metadata chain from a
+// Notary server in order to resolve a signed digest for a tag.
+type notaryClient struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+func newNotaryClient(baseURL string) *notaryClient {
+	return &notaryClient{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		baseURL:    baseURL,
+	}
+}
+
+// getTargetDigest returns the signed sha256 digest recorded for tag in
+// gun's targets role. It roots trust in gun's root.json, requiring it to
+// carry a valid signature from pinnedRootKey in addition to meeting its own
+// declared threshold, then requires a threshold of valid signatures from
+// root-pinned keys at every step of the timestamp -> snapshot -> targets
+// chain, in addition to the existing hash-chaining and expiration checks.
+func (n *notaryClient) getTargetDigest(ctx context.Context, gun, tag, pinnedRootKey string) (string, error) {
+	root, err := n.fetchVerifiedRoot(ctx, gun, pinnedRootKey)
+	if err != nil {
+		return "", err
+	}
+
+	timestampRole, err := requireRole(root, "timestamp")
+	if err != nil {
+		return "", err
+	}
+	_, timestampSigned, err := n.fetchSignedRole(ctx, gun, "timestamp", root, timestampRole)
+	if err != nil {
+		return "", err
+	}
+	var timestamp tufTimestampSigned
+	if err := json.Unmarshal(timestampSigned, &timestamp); err != nil {
+		return "", fmt.Errorf("invalid timestamp metadata for %s: %v", gun, err)
+	}
+	if time.Now().After(timestamp.Expires) {
+		return "", fmt.Errorf("timestamp metadata for %s expired on %s", gun, timestamp.Expires)
+	}
+
+	snapshotRole, err := requireRole(root, "snapshot")
+	if err != nil {
+		return "", err
+	}
+	snapshotRaw, snapshotSigned, err := n.fetchSignedRole(ctx, gun, "snapshot", root, snapshotRole)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyHash(snapshotRaw, timestamp.Meta["snapshot.json"]); err != nil {
+		return "", fmt.Errorf("snapshot metadata for %s: %v", gun, err)
+	}
+	var snapshot tufSnapshotSigned
+	if err := json.Unmarshal(snapshotSigned, &snapshot); err != nil {
+		return "", fmt.Errorf("invalid snapshot metadata for %s: %v", gun, err)
+	}
+	if time.Now().After(snapshot.Expires) {
+		return "", fmt.Errorf("snapshot metadata for %s expired on %s", gun, snapshot.Expires)
+	}
+
+	targetsRole, err := requireRole(root, "targets")
+	if err != nil {
+		return "", err
+	}
+	targetsRaw, targetsSigned, err := n.fetchSignedRole(ctx, gun, "targets", root, targetsRole)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyHash(targetsRaw, snapshot.Meta["targets.json"]); err != nil {
+		return "", fmt.Errorf("targets metadata for %s: %v", gun, err)
+	}
+	var targets tufTargetsSigned
+	if err := json.Unmarshal(targetsSigned, &targets); err != nil {
+		return "", fmt.Errorf("invalid targets metadata for %s: %v", gun, err)
+	}
+	if time.Now().After(targets.Expires) {
+		return "", fmt.Errorf("targets metadata for %s expired on %s", gun, targets.Expires)
+	}
+
+	target, ok := targets.Targets[tag]
+	if !ok {
+		return "", fmt.Errorf("no trust data for tag %q", tag)
+	}
+	sum, ok := target.Hashes["sha256"]
+	if !ok {
+		return "", fmt.Errorf("no sha256 hash recorded for tag %q", tag)
+	}
+
+	return "sha256:" + sum, nil
+}
+
+// requireRole looks up role in root's role delegations, failing if root
+// does not delegate it.
+func requireRole(root *tufRootSigned, role string) (tufRole, error) {
+	roleSpec, ok := root.Roles[role]
+	if !ok {
+		return tufRole{}, fmt.Errorf("root metadata does not delegate a %s role", role)
+	}
+	return roleSpec, nil
+}
+
+// fetchVerifiedRoot fetches gun's root.json and verifies it is signed by a
+// threshold of the keys it claims for its own root role, then verifies one
+// of those valid signers is pinnedRootKey. A self-consistent root.json is
+// not enough on its own: anyone can mint a root that validly signs itself,
+// so without an out-of-band anchor a compromised registry or a MITM could
+// serve its own root chain and have any digest accepted. pinnedRootKey must
+// therefore be supplied (from the caller's trust anchor, not from the
+// server), and is checked against the root's actual signers rather than
+// against whatever key root.json itself claims for the role.
+func (n *notaryClient) fetchVerifiedRoot(ctx context.Context, gun, pinnedRootKey string) (*tufRootSigned, error) {
+	if pinnedRootKey == "" {
+		return nil, fmt.Errorf("no pinned root key configured for %s; refusing to trust a self-asserted root", gun)
+	}
+
+	raw, err := n.fetchRole(ctx, gun, "root")
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope tufSigned
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid root metadata for %s: %v", gun, err)
+	}
+
+	var root tufRootSigned
+	if err := json.Unmarshal(envelope.Signed, &root); err != nil {
+		return nil, fmt.Errorf("invalid root metadata for %s: %v", gun, err)
+	}
+
+	rootRole, err := requireRole(&root, "root")
+	if err != nil {
+		return nil, fmt.Errorf("root metadata for %s: %v", gun, err)
+	}
+	if err := verifyThreshold(envelope, root.Keys, rootRole); err != nil {
+		return nil, fmt.Errorf("root metadata for %s: %v", gun, err)
+	}
+	if err := verifyRootPinned(envelope, root.Keys, rootRole, pinnedRootKey); err != nil {
+		return nil, fmt.Errorf("root metadata for %s: %v", gun, err)
+	}
+
+	if time.Now().After(root.Expires) {
+		return nil, fmt.Errorf("root metadata for %s expired on %s", gun, root.Expires)
+	}
+
+	return &root, nil
+}
+
+// fetchSignedRole fetches role's metadata and verifies it carries a
+// threshold of valid signatures from the keys root pins for it. It returns
+// both the raw (whole-file) bytes, for hash-chaining against the parent
+// role's recorded meta, and the decoded signed content.
+func (n *notaryClient) fetchSignedRole(ctx context.Context, gun, role string, root *tufRootSigned, roleSpec tufRole) (raw []byte, signed json.RawMessage, err error) {
+	raw, err = n.fetchRole(ctx, gun, role)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var envelope tufSigned
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s metadata for %s: %v", role, gun, err)
+	}
+
+	if err := verifyThreshold(envelope, root.Keys, roleSpec); err != nil {
+		return nil, nil, fmt.Errorf("%s metadata for %s: %v", role, gun, err)
+	}
+
+	return raw, envelope.Signed, nil
+}
+
+// fetchRole retrieves the raw bytes of a TUF role's metadata file for gun.
+func (n *notaryClient) fetchRole(ctx context.Context, gun, role string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, n.baseURL+"/v2/"+gun+"/_trust/tuf/"+role+".json", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("no %s trust data (server returned %s)", role, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// verifiedSigners returns the set of keyids from roleSpec.KeyIDs that
+// produced a valid signature over envelope's Signed content.
+func verifiedSigners(envelope tufSigned, keys map[string]tufKey, roleSpec tufRole) map[string]bool {
+	allowed := make(map[string]bool, len(roleSpec.KeyIDs))
+	for _, id := range roleSpec.KeyIDs {
+		allowed[id] = true
+	}
+
+	verified := make(map[string]bool)
+	for _, sig := range envelope.Signatures {
+		if !allowed[sig.KeyID] || verified[sig.KeyID] {
+			continue
+		}
+		key, ok := keys[sig.KeyID]
+		if !ok {
+			continue
+		}
+		if err := verifySignature(envelope.Signed, key, sig); err != nil {
+			continue
+		}
+		verified[sig.KeyID] = true
+	}
+	return verified
+}
+
+// verifyThreshold reports whether envelope carries valid signatures, over
+// its Signed content, from at least roleSpec.Threshold of the distinct keys
+// roleSpec names (looked up in keys). Signatures from keys outside
+// roleSpec.KeyIDs, or that fail to verify, are ignored rather than treated
+// as fatal: an attacker cannot meet the threshold merely by attaching
+// extra, unpinned signatures.
+func verifyThreshold(envelope tufSigned, keys map[string]tufKey, roleSpec tufRole) error {
+	verified := verifiedSigners(envelope, keys, roleSpec)
+	if roleSpec.Threshold <= 0 || len(verified) < roleSpec.Threshold {
+		return fmt.Errorf("got %d valid signature(s), need %d", len(verified), roleSpec.Threshold)
+	}
+	return nil
+}
+
+// verifyRootPinned reports whether at least one of envelope's valid signers
+// (per roleSpec) holds the caller-pinned root key, i.e. whether the root is
+// anchored to something the caller trusts rather than merely consistent
+// with itself.
+func verifyRootPinned(envelope tufSigned, keys map[string]tufKey, roleSpec tufRole, pinnedRootKey string) error {
+	for keyID := range verifiedSigners(envelope, keys, roleSpec) {
+		if keys[keyID].KeyVal.Public == pinnedRootKey {
+			return nil
+		}
+	}
+	return fmt.Errorf("root.json is not signed by the pinned root key")
+}
+
+// verifySignature checks that sig is a valid signature by key over the
+// exact bytes of signed.
+func verifySignature(signed json.RawMessage, key tufKey, sig tufSignature) error {
+	sigBytes, err := hex.DecodeString(sig.Sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	pubBytes, err := hex.DecodeString(key.KeyVal.Public)
+	if err != nil {
+		return fmt.Errorf("invalid key encoding: %v", err)
+	}
+
+	switch key.KeyType {
+	case "ed25519":
+		if len(pubBytes) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid ed25519 key length")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(pubBytes), signed, sigBytes) {
+			return fmt.Errorf("signature verification failed")
+		}
+		return nil
+	case "ecdsa":
+		pub, err := x509.ParsePKIXPublicKey(pubBytes)
+		if err != nil {
+			return fmt.Errorf("invalid ecdsa key: %v", err)
+		}
+		ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key is not an ecdsa public key")
+		}
+		if len(sigBytes)%2 != 0 {
+			return fmt.Errorf("invalid ecdsa signature length")
+		}
+		digest := sha256.Sum256(signed)
+		half := len(sigBytes) / 2
+		r := new(big.Int).SetBytes(sigBytes[:half])
+		s := new(big.Int).SetBytes(sigBytes[half:])
+		if !ecdsa.Verify(ecdsaPub, digest[:], r, s) {
+			return fmt.Errorf("signature verification failed")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %q", key.KeyType)
+	}
+}
+
+// verifyHash checks that content hashes to the sha256 sum recorded in want,
+// as required by the parent role that references it.
+func verifyHash(content []byte, want tufHash) error {
+	wantSum, ok := want.Hashes["sha256"]
+	if !ok {
+		return fmt.Errorf("no sha256 hash recorded by referencing role")
+	}
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != wantSum {
+		return fmt.Errorf("hash mismatch: expected %s, got %x", wantSum, sum)
+	}
+	return nil
+}